@@ -2,13 +2,18 @@ package cmd
 
 import (
 	"encoding/json"
+	"fmt"
 	ice "github.com/pion/ice/v2"
 	"github.com/wiretrustee/wiretrustee/connection"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 )
 
+// defaultConfigPath is where Execute looks for the config file if -config isn't given.
+const defaultConfigPath = "/etc/wiretrustee/config.json"
+
 // Config Configuration type
 type Config struct {
 	// Wireguard private key of local peer
@@ -20,6 +25,17 @@ type Config struct {
 	WgAddr         string
 	WgIface        string
 	IFaceBlackList []string
+	// RelayURLs is a list of websocket relay servers used as a fallback data
+	// path when ICE can't establish a direct P2P connection to a peer
+	RelayURLs []string
+	// RelaySharedKey signs the auth token a peer presents when connecting to a
+	// relay server in RelayURLs
+	RelaySharedKey string
+
+	// path is the file Peers is persisted to, set by Read. Unexported so it
+	// isn't marshaled into the config file itself.
+	path string
+	mux  sync.Mutex
 }
 
 //Write writes configPath to a file
@@ -64,6 +80,58 @@ func Read(path string) (*Config, error) {
 	if err != nil {
 		return nil, err
 	}
+	cfg.path = path
 
 	return &cfg, nil
 }
+
+// AddPeer appends peer to the config's peer list and persists it to disk,
+// implementing management.PeerStore so the management service can be driven by
+// a plain *Config instead of something more heavyweight.
+func (cfg *Config) AddPeer(peer connection.Peer) error {
+	cfg.mux.Lock()
+	defer cfg.mux.Unlock()
+
+	for _, p := range cfg.Peers {
+		if p.WgPubKey == peer.WgPubKey {
+			return fmt.Errorf("peer %s already exists", peer.WgPubKey)
+		}
+	}
+	cfg.Peers = append(cfg.Peers, peer)
+
+	return cfg.write()
+}
+
+// RemovePeer removes the peer with the given public key from the config's peer
+// list and persists it to disk.
+func (cfg *Config) RemovePeer(wgPubKey string) error {
+	cfg.mux.Lock()
+	defer cfg.mux.Unlock()
+
+	for i, p := range cfg.Peers {
+		if p.WgPubKey == wgPubKey {
+			cfg.Peers = append(cfg.Peers[:i], cfg.Peers[i+1:]...)
+			return cfg.write()
+		}
+	}
+
+	return fmt.Errorf("peer %s not found", wgPubKey)
+}
+
+// ListPeers returns a copy of the config's current peer list.
+func (cfg *Config) ListPeers() []connection.Peer {
+	cfg.mux.Lock()
+	defer cfg.mux.Unlock()
+
+	peers := make([]connection.Peer, len(cfg.Peers))
+	copy(peers, cfg.Peers)
+	return peers
+}
+
+// write persists the config to cfg.path. Callers must hold cfg.mux.
+func (cfg *Config) write() error {
+	if cfg.path == "" {
+		return fmt.Errorf("config has no path set, was it loaded via Read?")
+	}
+	return cfg.Write(cfg.path)
+}
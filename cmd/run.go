@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/wiretrustee/wiretrustee/connection"
+	"github.com/wiretrustee/wiretrustee/management"
+	"github.com/wiretrustee/wiretrustee/signal"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Execute is the CLI entry point called by main: it parses the -config flag,
+// loads the Config it points at, and runs the agent in the foreground. It
+// returns once Run does, i.e. on a fatal startup error or the management
+// service shutting down.
+func Execute() error {
+	configPath := flag.String("config", defaultConfigPath, "path to the wiretrustee config file")
+	flag.Parse()
+
+	cfg, err := Read(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed reading config %s: %w", *configPath, err)
+	}
+
+	return Run(cfg)
+}
+
+// Run starts the connection Engine for cfg and blocks serving the management
+// API on management.DefaultSocketPath, so peers can be added/removed/watched
+// at runtime for as long as the agent is up.
+func Run(cfg *Config) error {
+	myKey, err := wgtypes.ParseKey(cfg.PrivateKey)
+	if err != nil {
+		return fmt.Errorf("failed parsing private key: %w", err)
+	}
+
+	signalClient, err := signal.NewClient(cfg.SignalAddr, myKey)
+	if err != nil {
+		return fmt.Errorf("failed connecting to signal server %s: %w", cfg.SignalAddr, err)
+	}
+
+	iFaceBlackList := make(map[string]struct{}, len(cfg.IFaceBlackList))
+	for _, iface := range cfg.IFaceBlackList {
+		iFaceBlackList[iface] = struct{}{}
+	}
+
+	engine := connection.NewEngine(signalClient, cfg.StunTurnURLs, cfg.WgIface, cfg.WgAddr,
+		iFaceBlackList, cfg.RelayURLs, cfg.RelaySharedKey)
+	if err := engine.Start(myKey, cfg.ListPeers()); err != nil {
+		return fmt.Errorf("failed starting connection engine: %w", err)
+	}
+
+	mgmtSrv := management.NewServer(engine, cfg, engine.WgPort(), myKey)
+	return mgmtSrv.Listen(management.DefaultSocketPath, nil)
+}
@@ -0,0 +1,633 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	ice "github.com/pion/ice/v2"
+	log "github.com/sirupsen/logrus"
+	"github.com/wiretrustee/wiretrustee/iface"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// Status is the lifecycle state of a single peer Connection's ICE session.
+type Status int
+
+const (
+	ConnStatusIdle Status = iota
+	ConnStatusConnecting
+	ConnStatusConnected
+	ConnStatusDisconnected
+	ConnStatusClosed
+)
+
+// localUDPProxyMTU is the buffer size used when pumping packets between the
+// local WireGuard UDP socket and the remote peer's ICE connection.
+const localUDPProxyMTU = 1500
+
+// ConnConfig groups everything needed to establish one peer's P2P connection.
+type ConnConfig struct {
+	// WgListenAddr is the local Wireguard UDP listener's address, e.g. 127.0.0.1:51820
+	WgListenAddr string
+	// WgPeerIP is the local peer's Wireguard address (CIDR)
+	WgPeerIP string
+	// WgIfaceName is the local Wireguard interface name
+	WgIfaceName string
+	// WgIface is the already-created local Wireguard tunnel interface. Connection
+	// reuses this single instance for every UpdatePeer/RemovePeer call instead of
+	// constructing its own, since a fresh iface.NewWGIface hasn't had Create called
+	// on it and some backends (e.g. the userspace one) reject peer updates until it has.
+	WgIface *iface.WGIface
+	// WgAllowedIPs is the remote peer's allowed IPs on the WG interface
+	WgAllowedIPs string
+	// WgKey is the local Wireguard private key
+	WgKey wgtypes.Key
+	// RemoteWgKey is the remote peer's Wireguard public key
+	RemoteWgKey wgtypes.Key
+	// StunTurnURLS is the list of STUN/TURN servers used for ICE gathering
+	StunTurnURLS []*ice.URL
+	// iFaceBlackList is the set of local network interfaces ICE should ignore
+	// when gathering host candidates
+	iFaceBlackList map[string]struct{}
+	// RelayClient is the Engine-shared relay connection used as a fast initial
+	// data path (while ICE is still gathering/dialing) and as a fallback when
+	// ICE can't establish or keep a direct connection. Nil if no relay server is
+	// configured.
+	RelayClient *RelayClient
+}
+
+// dataPath is the data-plane backend currently behind a Connection's local UDP
+// proxy: either a direct ice.Conn (P2P mode) or a relayDataPath (relay mode).
+// Connection switches which one is active without ever touching the peer's
+// WireGuard entry, so the switch is transparent to WireGuard.
+type dataPath interface {
+	Read(p []byte) (int, error)
+	Write(p []byte) (int, error)
+	Close() error
+}
+
+// connMode records which dataPath backend a Connection is currently using.
+type connMode int
+
+const (
+	modeP2P connMode = iota
+	modeRelay
+)
+
+// relayDataPath adapts a RelayClient's per-peer frame stream to the dataPath
+// interface so Connection's pump can treat it exactly like an ice.Conn.
+type relayDataPath struct {
+	client    *RelayClient
+	remoteKey string
+	ch        chan []byte
+	pending   []byte
+}
+
+func (p *relayDataPath) Read(b []byte) (int, error) {
+	for len(p.pending) == 0 {
+		data, ok := <-p.ch
+		if !ok {
+			return 0, fmt.Errorf("relay stream for %s closed", p.remoteKey)
+		}
+		p.pending = data
+	}
+	n := copy(b, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *relayDataPath) Write(b []byte) (int, error) {
+	if err := p.client.Send(p.remoteKey, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (p *relayDataPath) Close() error {
+	// the underlying RelayClient is shared across every peer relayed through
+	// this Engine, so closing a single peer's path only unregisters its stream
+	p.client.Unregister(p.remoteKey)
+	return nil
+}
+
+// IceCredentials is a remote peer's ICE username fragment/password, exchanged
+// via the signal server before the two ICE agents dial each other.
+type IceCredentials struct {
+	uFrag string
+	pwd   string
+}
+
+// Connection manages one peer's ICE agent, the local UDP proxy that bridges it
+// to the WireGuard interface, and the corresponding WireGuard peer entry.
+type Connection struct {
+	Config ConnConfig
+	Status Status
+
+	signalCandidate func(candidate ice.Candidate) error
+	signalOffer     func(uFrag string, pwd string) error
+	signalAnswer    func(uFrag string, pwd string) error
+
+	remoteOffer  chan IceCredentials
+	remoteAnswer chan IceCredentials
+
+	mu         sync.Mutex
+	agent      *ice.Agent
+	localProxy *net.UDPConn
+	wgAddr     *net.UDPAddr
+	mode       connMode
+	active     dataPath
+	done       chan struct{}
+	closed     bool
+}
+
+// NewConnection creates a Connection for a single remote peer. The signal*
+// callbacks are used by Open to exchange ICE credentials and candidates with
+// the remote peer over the signal server; OnOffer/OnAnswer/OnRemoteCandidate
+// feed the other side's messages back in as they arrive.
+func NewConnection(config ConnConfig, signalCandidate func(candidate ice.Candidate) error,
+	signalOffer func(uFrag string, pwd string) error, signalAnswer func(uFrag string, pwd string) error) *Connection {
+	return &Connection{
+		Config:          config,
+		Status:          ConnStatusIdle,
+		signalCandidate: signalCandidate,
+		signalOffer:     signalOffer,
+		signalAnswer:    signalAnswer,
+		remoteOffer:     make(chan IceCredentials, 1),
+		remoteAnswer:    make(chan IceCredentials, 1),
+		done:            make(chan struct{}),
+	}
+}
+
+// Open establishes initial connectivity with the remote peer and points its
+// WireGuard entry at it. If a relay is configured, Open binds to the relay
+// path right away - it's usable immediately, unlike ICE, which still has to
+// gather candidates and dial - and negotiates ICE in the background,
+// transparently upgrading to it the moment (if) it succeeds. With no relay
+// configured, Open blocks on ICE negotiation the same way it always has.
+func (c *Connection) Open(timeout time.Duration) error {
+	c.mu.Lock()
+	c.Status = ConnStatusConnecting
+	c.mu.Unlock()
+
+	if c.Config.RelayClient != nil {
+		if err := c.openRelay(); err == nil {
+			go c.negotiateICE(timeout)
+			return nil
+		} else {
+			log.Warnf("failed getting an initial relay path to %s, falling back to ICE only: %s", c.Config.RemoteWgKey.String(), err)
+		}
+	}
+
+	return c.dialICE(timeout)
+}
+
+// negotiate creates a fresh ICE agent and exchanges credentials and candidates
+// with the remote peer over the signal server, blocking until the ICE pair
+// connects or timeout elapses. It neither binds the result to WireGuard nor
+// falls back to relay - callers decide what to do with the returned *ice.Conn.
+func (c *Connection) negotiate(timeout time.Duration) (_ *ice.Conn, err error) {
+	agent, err := ice.NewAgent(&ice.AgentConfig{
+		Urls:         c.Config.StunTurnURLS,
+		NetworkTypes: []ice.NetworkType{ice.NetworkTypeUDP4, ice.NetworkTypeUDP6},
+		InterfaceFilter: func(iFace string) bool {
+			_, blackListed := c.Config.iFaceBlackList[iFace]
+			return !blackListed
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed creating ICE agent for %s: %w", c.Config.RemoteWgKey.String(), err)
+	}
+
+	c.mu.Lock()
+	c.agent = agent
+	c.mu.Unlock()
+
+	// every return path below that fails must close this agent and clear
+	// c.agent - without this, a persistent peer that keeps hitting e.g.
+	// waitForCredentials timing out (the common "peer currently unreachable"
+	// case) leaks one *ice.Agent, and the UDP sockets/goroutines behind it, on
+	// every failed reconnect attempt for as long as the peer stays down.
+	defer func() {
+		if err != nil {
+			_ = agent.Close()
+			c.mu.Lock()
+			c.agent = nil
+			c.mu.Unlock()
+		}
+	}()
+
+	err = agent.OnCandidate(func(candidate ice.Candidate) {
+		if candidate == nil {
+			return
+		}
+		if err := c.signalCandidate(candidate); err != nil {
+			log.Errorf("failed signaling candidate to %s: %s", c.Config.RemoteWgKey.String(), err)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	localUFrag, localPwd, err := agent.GetLocalUserCredentials()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := agent.GatherCandidates(); err != nil {
+		return nil, fmt.Errorf("failed gathering ICE candidates for %s: %w", c.Config.RemoteWgKey.String(), err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// the peer with the lexicographically smaller public key is controlling and
+	// sends the OFFER; the other is controlled and answers, mirroring how the
+	// ICE spec expects exactly one side to dial and the other to accept
+	var remoteConn *ice.Conn
+	var dialErr error
+	if c.Config.WgKey.PublicKey().String() < c.Config.RemoteWgKey.String() {
+		if err := c.signalOffer(localUFrag, localPwd); err != nil {
+			return nil, fmt.Errorf("failed sending OFFER to %s: %w", c.Config.RemoteWgKey.String(), err)
+		}
+		remoteCred, err := c.waitForCredentials(ctx, c.remoteAnswer)
+		if err != nil {
+			return nil, err
+		}
+		remoteConn, dialErr = agent.Dial(ctx, remoteCred.uFrag, remoteCred.pwd)
+	} else {
+		remoteCred, err := c.waitForCredentials(ctx, c.remoteOffer)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.signalAnswer(localUFrag, localPwd); err != nil {
+			return nil, fmt.Errorf("failed sending ANSWER to %s: %w", c.Config.RemoteWgKey.String(), err)
+		}
+		remoteConn, dialErr = agent.Accept(ctx, remoteCred.uFrag, remoteCred.pwd)
+	}
+
+	if dialErr != nil {
+		return nil, dialErr
+	}
+
+	return remoteConn, nil
+}
+
+// dialICE negotiates a direct ICE connection and binds it to WireGuard,
+// blocking until it succeeds or timeout elapses. If it fails and a relay is
+// configured, it falls back to the relay path instead of failing outright.
+func (c *Connection) dialICE(timeout time.Duration) error {
+	remoteConn, err := c.negotiate(timeout)
+	if err != nil {
+		if c.Config.RelayClient != nil {
+			log.Warnf("failed establishing a direct P2P connection to %s, falling back to relay: %s", c.Config.RemoteWgKey.String(), err)
+			return c.openRelay()
+		}
+		return fmt.Errorf("failed establishing a direct P2P connection to %s: %w", c.Config.RemoteWgKey.String(), err)
+	}
+
+	if err := c.bindWireguardPeer(remoteConn, modeP2P); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.Status = ConnStatusConnected
+	c.mu.Unlock()
+
+	go c.startReader(remoteConn)
+
+	return nil
+}
+
+// negotiateICE runs ICE negotiation in the background while a relay path is
+// already carrying this Connection's traffic, transparently upgrading to the
+// direct connection if and when ICE succeeds within timeout. If ICE never
+// succeeds (or this Connection is closed first), the relay path keeps serving
+// traffic.
+func (c *Connection) negotiateICE(timeout time.Duration) {
+	remoteConn, err := c.negotiate(timeout)
+	if err != nil {
+		log.Warnf("ICE negotiation with %s didn't succeed, staying on the relay path: %s", c.Config.RemoteWgKey.String(), err)
+		return
+	}
+	c.upgradeToP2P(remoteConn)
+}
+
+// waitForCredentials blocks until the remote peer's ICE credentials arrive on ch
+// (via OnOffer/OnAnswer) or ctx expires.
+func (c *Connection) waitForCredentials(ctx context.Context, ch chan IceCredentials) (IceCredentials, error) {
+	select {
+	case cred := <-ch:
+		return cred, nil
+	case <-ctx.Done():
+		return IceCredentials{}, fmt.Errorf("timed out waiting for ICE credentials from %s", c.Config.RemoteWgKey.String())
+	}
+}
+
+// bindWireguardPeer opens a local UDP proxy bridging the WireGuard interface's
+// UDP socket to path, then points the peer's WireGuard entry at that local
+// proxy. Later switching path (see downgradeToRelay) never calls this again -
+// the WireGuard entry keeps pointing at the same local proxy regardless of
+// which backend is behind it, so a P2P/relay switch never touches WireGuard.
+func (c *Connection) bindWireguardPeer(path dataPath, mode connMode) error {
+	localProxy, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 0})
+	if err != nil {
+		return fmt.Errorf("failed opening local proxy socket for %s: %w", c.Config.RemoteWgKey.String(), err)
+	}
+
+	c.mu.Lock()
+	c.localProxy = localProxy
+	c.active = path
+	c.mode = mode
+	c.mu.Unlock()
+
+	go c.pumpToRemote(localProxy)
+
+	return c.Config.WgIface.UpdatePeer(c.Config.RemoteWgKey.String(), c.Config.WgAllowedIPs, localProxy.LocalAddr().(*net.UDPAddr))
+}
+
+// pumpToRemote forwards packets the local WireGuard interface sends to
+// localProxy on to whichever dataPath is currently active, so a P2P/relay
+// switch is transparent to this loop.
+func (c *Connection) pumpToRemote(localProxy *net.UDPConn) {
+	buf := make([]byte, localUDPProxyMTU)
+	for {
+		n, addr, err := localProxy.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		c.mu.Lock()
+		c.wgAddr = addr
+		path := c.active
+		c.mu.Unlock()
+		if path == nil {
+			continue
+		}
+
+		if _, err := path.Write(buf[:n]); err != nil {
+			log.Warnf("failed writing to %s's data path: %s", c.Config.RemoteWgKey.String(), err)
+		}
+	}
+}
+
+// startReader pumps packets arriving on path back to the local WireGuard
+// interface until path's Read loop ends. A P2P path ending triggers a
+// downgrade to relay (if configured and path is still the active one); a
+// relay path ending, or a P2P path ending with no relay to fall back to, is
+// reported on Done().
+func (c *Connection) startReader(path dataPath) {
+	buf := make([]byte, localUDPProxyMTU)
+	for {
+		n, err := path.Read(buf)
+		if err != nil {
+			c.handlePathDrop(path)
+			return
+		}
+
+		c.mu.Lock()
+		wgAddr := c.wgAddr
+		localProxy := c.localProxy
+		c.mu.Unlock()
+		if wgAddr == nil || localProxy == nil {
+			continue
+		}
+
+		if _, err := localProxy.WriteToUDP(buf[:n], wgAddr); err != nil {
+			return
+		}
+	}
+}
+
+// handlePathDrop reacts to path's Read loop ending. If path is still the
+// active backend (it hasn't already been superseded by another switch), a P2P
+// drop is downgraded to relay when one is configured; anything else means this
+// Connection is as down as it can get, so it's reported on Done().
+func (c *Connection) handlePathDrop(path dataPath) {
+	c.mu.Lock()
+	stillActive := c.active == path && !c.closed
+	wasRelay := c.mode == modeRelay
+	c.mu.Unlock()
+
+	if !stillActive {
+		return
+	}
+
+	if !wasRelay {
+		if err := c.downgradeToRelay(); err == nil {
+			return
+		}
+	}
+
+	c.markDone()
+}
+
+// openRelay binds this Connection to the shared relay client. It's used both
+// as Open's fast initial path (while ICE negotiates in the background) and as
+// the fallback when a blocking ICE dial/accept fails outright.
+func (c *Connection) openRelay() error {
+	path, err := c.dialRelay()
+	if err != nil {
+		return fmt.Errorf("failed connecting to %s over relay: %w", c.Config.RemoteWgKey.String(), err)
+	}
+
+	if err := c.bindWireguardPeer(path, modeRelay); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.Status = ConnStatusConnected
+	c.mu.Unlock()
+
+	go c.startReader(path)
+	return nil
+}
+
+// downgradeToRelay transparently switches an already-open Connection's active
+// data path from direct P2P to the shared relay client, without touching the
+// peer's WireGuard entry - WireGuard keeps sending to the same local proxy
+// socket regardless of which backend is behind it. It also kicks off a
+// background ICE renegotiation so the Connection can upgrade back to P2P if
+// the drop was transient.
+func (c *Connection) downgradeToRelay() error {
+	path, err := c.dialRelay()
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.active = path
+	c.mode = modeRelay
+	c.mu.Unlock()
+
+	log.Warnf("peer %s downgraded to relay path", c.Config.RemoteWgKey.String())
+	go c.startReader(path)
+	go c.negotiateICE(PeerConnectionTimeout)
+	return nil
+}
+
+// upgradeToP2P transparently switches an already-open Connection's active
+// data path from relay to the freshly negotiated direct P2P connection,
+// mirroring downgradeToRelay. remoteConn is closed instead if this Connection
+// was closed while ICE was negotiating in the background.
+func (c *Connection) upgradeToP2P(remoteConn *ice.Conn) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		_ = remoteConn.Close()
+		return
+	}
+	oldActive := c.active
+	c.active = remoteConn
+	c.mode = modeP2P
+	c.mu.Unlock()
+
+	// oldActive is the relayDataPath this Connection was using while ICE
+	// negotiated in the background (the common case: relay first, then
+	// upgrade once ICE succeeds). Close it so its startReader goroutine
+	// unblocks and its stream is unregistered from the shared RelayClient -
+	// otherwise every successful upgrade leaks both.
+	if oldActive != nil {
+		_ = oldActive.Close()
+	}
+
+	log.Infof("peer %s upgraded to a direct P2P connection", c.Config.RemoteWgKey.String())
+	go c.startReader(remoteConn)
+}
+
+// dialRelay registers a stream for this peer on the Engine-shared relay
+// client, returning it wrapped as a dataPath.
+func (c *Connection) dialRelay() (dataPath, error) {
+	if c.Config.RelayClient == nil {
+		return nil, fmt.Errorf("no relay client configured for %s", c.Config.RemoteWgKey.String())
+	}
+
+	remoteKey := c.Config.RemoteWgKey.String()
+	ch, err := c.Config.RelayClient.Register(remoteKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed registering relay stream for %s: %w", c.Config.RemoteWgKey.String(), err)
+	}
+	return &relayDataPath{client: c.Config.RelayClient, remoteKey: remoteKey, ch: ch}, nil
+}
+
+// markDone closes done exactly once.
+func (c *Connection) markDone() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+}
+
+// Done returns a channel that's closed once this Connection has no data path
+// left to the remote peer - a P2P drop only closes it if there's no relay to
+// downgrade to. It says nothing about the peer's WireGuard entry - callers
+// (e.g. Engine's persistent-peer supervisor) decide what to do about a drop.
+func (c *Connection) Done() <-chan struct{} {
+	return c.done
+}
+
+// resetICE tears down just the ICE agent, its data pump and local proxy socket
+// so a fresh Open can redial - unlike Close, it leaves the peer's WireGuard
+// entry (and allowed IPs) in place, so the peer's WireGuard entry is never
+// removed as part of a reconnect.
+func (c *Connection) resetICE() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+
+	if c.localProxy != nil {
+		_ = c.localProxy.Close()
+		c.localProxy = nil
+	}
+	if c.active != nil {
+		_ = c.active.Close()
+		c.active = nil
+	}
+	if c.agent != nil {
+		_ = c.agent.Close()
+		c.agent = nil
+	}
+
+	c.Status = ConnStatusDisconnected
+	c.done = make(chan struct{})
+}
+
+// Close tears the connection down for good: stops the data pump, closes the
+// ICE agent and local proxy socket, and removes the peer's WireGuard entry.
+// Idempotent - safe to call more than once.
+func (c *Connection) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	c.Status = ConnStatusClosed
+	localProxy, active, agent := c.localProxy, c.active, c.agent
+	select {
+	case <-c.done:
+	default:
+		close(c.done)
+	}
+	c.mu.Unlock()
+
+	var firstErr error
+	if localProxy != nil {
+		if err := localProxy.Close(); err != nil {
+			firstErr = err
+		}
+	}
+	if active != nil {
+		_ = active.Close()
+	}
+	if agent != nil {
+		_ = agent.Close()
+	}
+
+	if err := c.Config.WgIface.RemovePeer(c.Config.RemoteWgKey.String()); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	return firstErr
+}
+
+// OnOffer feeds a remote OFFER credential (received over the signal server)
+// into a blocked Open call.
+func (c *Connection) OnOffer(cred IceCredentials) error {
+	select {
+	case c.remoteOffer <- cred:
+	default:
+	}
+	return nil
+}
+
+// OnAnswer feeds a remote ANSWER credential (received over the signal server)
+// into a blocked Open call.
+func (c *Connection) OnAnswer(cred IceCredentials) error {
+	select {
+	case c.remoteAnswer <- cred:
+	default:
+	}
+	return nil
+}
+
+// OnRemoteCandidate feeds a remote ICE candidate (received over the signal
+// server) into the local ICE agent.
+func (c *Connection) OnRemoteCandidate(candidate ice.Candidate) error {
+	c.mu.Lock()
+	agent := c.agent
+	c.mu.Unlock()
+
+	if agent == nil {
+		return fmt.Errorf("received a candidate for %s before its ICE agent was created", c.Config.RemoteWgKey.String())
+	}
+	return agent.AddRemoteCandidate(candidate)
+}
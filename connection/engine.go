@@ -1,10 +1,8 @@
 package connection
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"net/http"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
@@ -29,91 +27,195 @@ type Engine struct {
 	signal *signal.Client
 	// peer agents indexed by local public key of the remote peers
 	conns map[string]*Connection
-	// Wireguard interface
-	wgIface string
+	// Wireguard interface name
+	wgIfaceName string
+	// wgIface is the constructed Wireguard tunnel interface, set once Start runs
+	wgIface *iface.WGIface
 	// Wireguard local address
 	wgIP string
 	// Network Interfaces to ignore
 	iFaceBlackList map[string]struct{}
 	// PeerMux is used to sync peer operations (e.g. open connection, peer removal)
 	PeerMux *sync.Mutex
+	// relayURLs is a list of websocket relay servers used as a fallback data path
+	// when ICE can't establish a direct P2P connection
+	relayURLs []string
+	// relaySharedKey signs the auth token a Connection presents when it falls
+	// back to a relay server
+	relaySharedKey string
+	// relayClient is the single relay connection shared by every peer's
+	// Connection, multiplexed by remote peer key. Constructed once in Start; nil
+	// if no relay server is configured or the initial connect attempt failed.
+	relayClient *RelayClient
+	// peerStatuses tracks the latest known connection health of each peer, indexed
+	// by the peer's public key. Guarded by PeerMux.
+	peerStatuses map[string]*PeerStatus
+	// statusSubs is the set of channels notified whenever any peer's status
+	// changes, driving the management service's WatchPeers stream. Guarded by
+	// PeerMux.
+	statusSubs map[chan struct{}]struct{}
+	// wgPort and myKey are set by Start and exposed via WgPort/WgKey so the
+	// management service can dial peers added after Start has run
+	wgPort int
+	myKey  wgtypes.Key
+	// outboundNonces is a strictly increasing per-peer counter used as the replay
+	// nonce for signal envelopes this Engine sends. Guarded by PeerMux.
+	outboundNonces map[string]uint64
+	// inboundNonces rejects any signal envelope whose nonce isn't strictly greater
+	// than the last one accepted from that peer.
+	inboundNonces *signalNonces
+	// removalGen is bumped on every RemovePeerConnection call for a peer, whether
+	// or not conns already had an entry for it. InitializePeer captures the generation in effect
+	// when it starts, and openPeerConnection refuses to (re-)insert into conns
+	// if the generation has since moved on - i.e. the peer was removed while a
+	// connection attempt (initial dial or a persistent-peer reconnect) for it
+	// was still in flight. Guarded by PeerMux.
+	removalGen map[string]int
 }
 
 // Peer is an instance of the Connection Peer
 type Peer struct {
 	WgPubKey     string
 	WgAllowedIps string
+	// Persistent indicates that Engine should keep redialing this peer (using the
+	// same exponential backoff as the initial connection attempt) whenever its
+	// Connection drops after having been successfully established
+	Persistent bool
+}
+
+// ConnectionState describes where a peer's Connection currently sits in its lifecycle.
+type ConnectionState int
+
+const (
+	StatusConnecting ConnectionState = iota
+	StatusConnected
+	StatusDisconnected
+)
+
+// PeerStatus is a snapshot of a peer Connection's health, used by callers (e.g. a
+// status CLI) to tell what's going on with a given peer without reaching into conns.
+type PeerStatus struct {
+	State             ConnectionState
+	LastError         error
+	ReconnectAttempts int
 }
 
 // NewEngine creates a new Connection Engine
 func NewEngine(signal *signal.Client, stunsTurns []*ice.URL, wgIface string, wgAddr string,
-	iFaceBlackList map[string]struct{}) *Engine {
+	iFaceBlackList map[string]struct{}, relayURLs []string, relaySharedKey string) *Engine {
 	return &Engine{
 		stunsTurns:     stunsTurns,
 		signal:         signal,
-		wgIface:        wgIface,
+		wgIfaceName:    wgIface,
 		wgIP:           wgAddr,
 		conns:          map[string]*Connection{},
 		iFaceBlackList: iFaceBlackList,
 		PeerMux:        &sync.Mutex{},
+		relayURLs:      relayURLs,
+		relaySharedKey: relaySharedKey,
+		peerStatuses:   map[string]*PeerStatus{},
+		statusSubs:     map[chan struct{}]struct{}{},
+		outboundNonces: map[string]uint64{},
+		inboundNonces:  newSignalNonces(),
+		removalGen:     map[string]int{},
+	}
+}
+
+// errPeerRemoved is returned by openPeerConnection when a peer's removal raced
+// with its own connection attempt and the removal won, so the attempt must not
+// insert its Connection into conns (or retry).
+var errPeerRemoved = errors.New("peer was removed before its connection attempt completed")
+
+// nextOutboundNonce returns the next strictly increasing nonce to use for a
+// signal envelope sent to remoteKey. The counter for a peer is seeded from the
+// current wall-clock time (nanoseconds) the first time it's needed, rather
+// than from zero: both sides' WireGuard identities are persisted across
+// restarts (cmd.Config.PrivateKey), but outboundNonces/inboundNonces live only
+// in memory, so a bare 0-based counter would restart below whatever the other
+// side's inboundNonces already remembers from before the restart and get every
+// subsequent message rejected as a replay. Seeding from time.Now() instead
+// means a freshly restarted process' first nonce is virtually guaranteed to be
+// greater than anything it (or its peer) sent before the restart.
+func (e *Engine) nextOutboundNonce(remoteKey string) uint64 {
+	e.PeerMux.Lock()
+	defer e.PeerMux.Unlock()
+	if _, ok := e.outboundNonces[remoteKey]; !ok {
+		e.outboundNonces[remoteKey] = uint64(time.Now().UnixNano())
+	} else {
+		e.outboundNonces[remoteKey]++
 	}
+	return e.outboundNonces[remoteKey]
 }
 
 // Start creates a new tunnel interface and listens to signals from the Signal service.
-// It also creates an Go routine to handle each peer communication from the config file
+// It also creates an Go routine to handle each peer communication from the config file.
+// Peers added or removed after Start are driven through the management gRPC service
+// (see the management package) rather than through the Engine directly.
 func (e *Engine) Start(myKey wgtypes.Key, peers []Peer) error {
 
-	err := iface.Create(e.wgIface, e.wgIP)
+	wgIface, err := iface.NewWGIface(e.wgIfaceName, e.wgIP)
 	if err != nil {
-		log.Errorf("error while creating interface %s: [%s]", e.wgIface, err.Error())
+		log.Errorf("error while building interface %s: [%s]", e.wgIfaceName, err.Error())
 		return err
 	}
+	e.wgIface = wgIface
 
-	err = iface.Configure(e.wgIface, myKey.String())
+	err = wgIface.Create()
 	if err != nil {
-		log.Errorf("error while configuring Wireguard interface [%s]: %s", e.wgIface, err.Error())
+		log.Errorf("error while creating interface %s: [%s]", e.wgIfaceName, err.Error())
 		return err
 	}
 
-	wgPort, err := iface.GetListenPort(e.wgIface)
+	err = wgIface.Configure(myKey.String())
 	if err != nil {
-		log.Errorf("error while getting Wireguard interface port [%s]: %s", e.wgIface, err.Error())
+		log.Errorf("error while configuring Wireguard interface [%s]: %s", e.wgIfaceName, err.Error())
 		return err
 	}
 
+	wgPort, err := wgIface.ListenPort()
+	if err != nil {
+		log.Errorf("error while getting Wireguard interface port [%s]: %s", e.wgIfaceName, err.Error())
+		return err
+	}
+	e.wgPort = wgPort
+	e.myKey = myKey
+
+	if len(e.relayURLs) > 0 {
+		relayClient := NewRelayClient(e.relayURLs[0], myKey, e.relaySharedKey)
+		if err := relayClient.Connect(); err != nil {
+			log.Warnf("failed connecting to relay server %s, peers will rely on P2P only: %s", e.relayURLs[0], err)
+		} else {
+			e.relayClient = relayClient
+		}
+	}
+
 	e.receiveSignal()
 
 	for _, peer := range peers {
 		peer := peer
-		go e.InitializePeer(*wgPort, myKey, peer)
+		go e.InitializePeer(wgPort, myKey, peer)
 	}
 
-	go func() {
-		http.HandleFunc("/peer", func(w http.ResponseWriter, r *http.Request) {
-			body, err := ioutil.ReadAll(r.Body)
-			if err != nil {
-				log.Error("%s", err)
-				return
-			}
-			var peer Peer
-			err = json.Unmarshal(body, &peer)
-			if err != nil {
-				log.Error("%s", err)
-				return
-			}
-			go e.InitializePeer(*wgPort, myKey, peer)
-		})
-		err := http.ListenAndServe("127.0.0.1:7777", nil)
-		if err != nil {
-			log.Fatal(err)
-		}
-	}()
 	return nil
 }
 
-// InitializePeer peer agent attempt to open connection
-func (e *Engine) InitializePeer(wgPort int, myKey wgtypes.Key, peer Peer) {
-	var backOff = &backoff.ExponentialBackOff{
+// WgPort returns the local Wireguard interface's listen port, set once Start has
+// run. Used by the management service to dial newly added peers.
+func (e *Engine) WgPort() int {
+	return e.wgPort
+}
+
+// WgKey returns the local Wireguard private key, set once Start has run. Used by
+// the management service to dial newly added peers.
+func (e *Engine) WgKey() wgtypes.Key {
+	return e.myKey
+}
+
+// newConnectBackOff returns the exponential backoff schedule used both for the
+// initial dial of a peer and for persistent peers' reconnect loop: 500ms initial
+// interval, 1.5 multiplier, 0.5 randomization, capped at 5s, retrying forever.
+func newConnectBackOff() *backoff.ExponentialBackOff {
+	return &backoff.ExponentialBackOff{
 		InitialInterval:     backoff.DefaultInitialInterval,
 		RandomizationFactor: backoff.DefaultRandomizationFactor,
 		Multiplier:          backoff.DefaultMultiplier,
@@ -122,8 +224,37 @@ func (e *Engine) InitializePeer(wgPort int, myKey wgtypes.Key, peer Peer) {
 		Stop:                backoff.Stop,
 		Clock:               backoff.SystemClock,
 	}
+}
+
+// InitializePeer peer agent attempt to open connection. Once open, if peer.Persistent
+// is set, a supervisor is started to redial the peer with the same backoff schedule
+// whenever the Connection drops.
+func (e *Engine) InitializePeer(wgPort int, myKey wgtypes.Key, peer Peer) {
+	e.PeerMux.Lock()
+	gen := e.removalGen[peer.WgPubKey]
+	e.PeerMux.Unlock()
+
+	e.initializePeer(wgPort, myKey, peer, gen)
+}
+
+// initializePeer is InitializePeer's body, parameterized on the removalGen the
+// caller observed for peer.WgPubKey. InitializePeer reads the current
+// generation itself; supervisePeer instead passes the generation it already
+// captured while checking conns for a dropped persistent peer, so there's no
+// window between that check and the generation read for a racing
+// RemovePeerConnection to bump it unseen.
+func (e *Engine) initializePeer(wgPort int, myKey wgtypes.Key, peer Peer, gen int) {
+	e.setPeerStatus(peer.WgPubKey, &PeerStatus{State: StatusConnecting})
+
+	attempt := 0
 	operation := func() error {
-		_, err := e.openPeerConnection(wgPort, myKey, peer)
+		attempt++
+		conn, err := e.openPeerConnection(wgPort, myKey, peer, gen)
+		if errors.Is(err, errPeerRemoved) {
+			log.Infof("peer %s was removed, not retrying", peer.WgPubKey)
+			return nil
+		}
+
 		e.PeerMux.Lock()
 		defer e.PeerMux.Unlock()
 		if _, ok := e.conns[peer.WgPubKey]; !ok {
@@ -134,22 +265,108 @@ func (e *Engine) InitializePeer(wgPort int, myKey wgtypes.Key, peer Peer) {
 		if err != nil {
 			log.Warnln(err)
 			log.Warnln("retrying connection because of error: ", err.Error())
+			e.setPeerStatus(peer.WgPubKey, &PeerStatus{State: StatusConnecting, LastError: err, ReconnectAttempts: attempt})
 			return err
 		}
+
+		e.setPeerStatus(peer.WgPubKey, &PeerStatus{State: StatusConnected})
+		if peer.Persistent {
+			go e.supervisePeer(wgPort, myKey, peer, conn)
+		}
 		return nil
 	}
 
-	err := backoff.Retry(operation, backOff)
+	err := backoff.Retry(operation, newConnectBackOff())
 	if err != nil {
 		// should actually never happen
 		panic(err)
 	}
 }
 
+// supervisePeer blocks until a persistent peer's Connection signals it dropped, then
+// resets its (now stale) ICE agent and re-enters the same backoff dial loop used
+// for the initial connection, sending fresh OFFER/ANSWER via the signal server
+// without ever removing the peer's WireGuard entry - unlike RemovePeerConnection's
+// Close, resetICE leaves that entry in place so traffic resumes as soon as a new
+// ICE session comes up.
+func (e *Engine) supervisePeer(wgPort int, myKey wgtypes.Key, peer Peer, conn *Connection) {
+	<-conn.Done()
+
+	e.PeerMux.Lock()
+	current, exists := e.conns[peer.WgPubKey]
+	if !exists || current != conn {
+		// peer was removed, or already reconnected through another path
+		e.PeerMux.Unlock()
+		return
+	}
+	// capture the generation here, in the same critical section as the conns
+	// check above, and carry it through to initializePeer ourselves - reading
+	// it later (e.g. inside InitializePeer, after resetICE's teardown work)
+	// would leave a window where a racing RemovePeerConnection's bump goes
+	// unseen and this reconnect resurrects the peer it just removed.
+	gen := e.removalGen[peer.WgPubKey]
+	e.PeerMux.Unlock()
+
+	log.Warnf("persistent peer %s disconnected, reconnecting", peer.WgPubKey)
+	e.setPeerStatus(peer.WgPubKey, &PeerStatus{State: StatusDisconnected})
+
+	conn.resetICE()
+
+	e.initializePeer(wgPort, myKey, peer, gen)
+}
+
+// setPeerStatus records the latest known connection health for a peer and wakes
+// up anyone watching via SubscribeStatusChanges.
+func (e *Engine) setPeerStatus(peerKey string, status *PeerStatus) {
+	e.PeerMux.Lock()
+	defer e.PeerMux.Unlock()
+	e.peerStatuses[peerKey] = status
+	for ch := range e.statusSubs {
+		select {
+		case ch <- struct{}{}:
+		default:
+			// a notification is already pending on this channel, the subscriber
+			// will pick up the latest status when it gets to it
+		}
+	}
+}
+
+// PeerStatus returns the last known connection state, error and reconnect attempt
+// count for a peer, or nil if the peer is unknown to the Engine.
+func (e *Engine) PeerStatus(peerKey string) *PeerStatus {
+	e.PeerMux.Lock()
+	defer e.PeerMux.Unlock()
+	return e.peerStatuses[peerKey]
+}
+
+// SubscribeStatusChanges registers a channel that receives a (non-blocking,
+// coalesced) notification whenever any peer's status changes. Used by the
+// management service to drive WatchPeers. Call the returned cancel func once
+// done watching.
+func (e *Engine) SubscribeStatusChanges() (changed <-chan struct{}, cancel func()) {
+	ch := make(chan struct{}, 1)
+
+	e.PeerMux.Lock()
+	e.statusSubs[ch] = struct{}{}
+	e.PeerMux.Unlock()
+
+	return ch, func() {
+		e.PeerMux.Lock()
+		delete(e.statusSubs, ch)
+		e.PeerMux.Unlock()
+	}
+}
+
 // RemovePeerConnection closes existing peer connection and removes peer
 func (e *Engine) RemovePeerConnection(peer Peer) error {
 	e.PeerMux.Lock()
 	defer e.PeerMux.Unlock()
+	// bump removalGen unconditionally, not just when conns already has an
+	// entry: a connection attempt for this peer may still be between
+	// InitializePeer capturing its gen and openPeerConnection inserting into
+	// conns, and it needs to see a moved-on generation too, or it silently
+	// resurrects the peer this call just asked to remove.
+	e.removalGen[peer.WgPubKey]++
 	conn, exists := e.conns[peer.WgPubKey]
 	if exists && conn != nil {
 		delete(e.conns, peer.WgPubKey)
@@ -171,33 +388,44 @@ func (e *Engine) GetPeerConnectionStatus(peerKey string) *Status {
 	return nil
 }
 
-// opens a new peer connection
-func (e *Engine) openPeerConnection(wgPort int, myKey wgtypes.Key, peer Peer) (*Connection, error) {
+// opens a new peer connection. gen is the removalGen for peer.WgPubKey that was
+// in effect when the caller (InitializePeer) started; if the peer has since
+// been removed (removalGen moved on), openPeerConnection refuses to insert its
+// Connection into conns and returns errPeerRemoved instead of silently
+// resurrecting a peer that was just removed.
+func (e *Engine) openPeerConnection(wgPort int, myKey wgtypes.Key, peer Peer, gen int) (*Connection, error) {
 	e.PeerMux.Lock()
 
+	if e.removalGen[peer.WgPubKey] != gen {
+		e.PeerMux.Unlock()
+		return nil, errPeerRemoved
+	}
+
 	remoteKey, _ := wgtypes.ParseKey(peer.WgPubKey)
 	connConfig := &ConnConfig{
 		WgListenAddr:   fmt.Sprintf("127.0.0.1:%d", wgPort),
 		WgPeerIP:       e.wgIP,
+		WgIfaceName:    e.wgIfaceName,
 		WgIface:        e.wgIface,
 		WgAllowedIPs:   peer.WgAllowedIps,
 		WgKey:          myKey,
 		RemoteWgKey:    remoteKey,
 		StunTurnURLS:   e.stunsTurns,
 		iFaceBlackList: e.iFaceBlackList,
+		RelayClient:    e.relayClient,
 	}
 
 	signalOffer := func(uFrag string, pwd string) error {
-		return signalAuth(uFrag, pwd, myKey, remoteKey, e.signal, false)
+		return e.signalAuth(uFrag, pwd, myKey, remoteKey, false)
 	}
 
 	signalAnswer := func(uFrag string, pwd string) error {
-		return signalAuth(uFrag, pwd, myKey, remoteKey, e.signal, true)
+		return e.signalAuth(uFrag, pwd, myKey, remoteKey, true)
 	}
-	signalCandidate := func(candidate ice.Candidate) error {
-		return signalCandidate(candidate, myKey, remoteKey, e.signal)
+	signalCandidateFn := func(candidate ice.Candidate) error {
+		return e.signalCandidate(candidate, myKey, remoteKey)
 	}
-	conn := NewConnection(*connConfig, signalCandidate, signalOffer, signalAnswer)
+	conn := NewConnection(*connConfig, signalCandidateFn, signalOffer, signalAnswer)
 	e.conns[remoteKey.String()] = conn
 	e.PeerMux.Unlock()
 
@@ -209,13 +437,21 @@ func (e *Engine) openPeerConnection(wgPort int, myKey wgtypes.Key, peer Peer) (*
 	return conn, nil
 }
 
-func signalCandidate(candidate ice.Candidate, myKey wgtypes.Key, remoteKey wgtypes.Key, s *signal.Client) error {
-	err := s.Send(&sProto.Message{
+// signalCandidate sends a local ICE candidate to remoteKey over the signal
+// server, sealed under the ECDH shared secret between myKey and remoteKey so the
+// (untrusted) signal server only ever sees ciphertext.
+func (e *Engine) signalCandidate(candidate ice.Candidate, myKey wgtypes.Key, remoteKey wgtypes.Key) error {
+	payload, err := sealSignalPayload(myKey, remoteKey, e.nextOutboundNonce(remoteKey.String()), candidate.Marshal())
+	if err != nil {
+		return fmt.Errorf("failed encrypting candidate for %s: %w", remoteKey.String(), err)
+	}
+
+	err = e.signal.Send(&sProto.Message{
 		Key:       myKey.PublicKey().String(),
 		RemoteKey: remoteKey.String(),
 		Body: &sProto.Body{
 			Type:    sProto.Body_CANDIDATE,
-			Payload: candidate.Marshal(),
+			Payload: payload,
 		},
 	})
 	if err != nil {
@@ -227,7 +463,9 @@ func signalCandidate(candidate ice.Candidate, myKey wgtypes.Key, remoteKey wgtyp
 	return nil
 }
 
-func signalAuth(uFrag string, pwd string, myKey wgtypes.Key, remoteKey wgtypes.Key, s *signal.Client, isAnswer bool) error {
+// signalAuth sends a local OFFER/ANSWER ICE credential to remoteKey over the
+// signal server, sealed under the ECDH shared secret between myKey and remoteKey.
+func (e *Engine) signalAuth(uFrag string, pwd string, myKey wgtypes.Key, remoteKey wgtypes.Key, isAnswer bool) error {
 
 	var t sProto.Body_Type
 	if isAnswer {
@@ -242,12 +480,14 @@ func signalAuth(uFrag string, pwd string, myKey wgtypes.Key, remoteKey wgtypes.K
 	if err != nil {
 		return err
 	}
-	err = s.Send(msg)
+
+	sealed, err := sealSignalPayload(myKey, remoteKey, e.nextOutboundNonce(remoteKey.String()), msg.GetBody().Payload)
 	if err != nil {
-		return err
+		return fmt.Errorf("failed encrypting signal message for %s: %w", remoteKey.String(), err)
 	}
+	msg.Body.Payload = sealed
 
-	return nil
+	return e.signal.Send(msg)
 }
 
 func (e *Engine) receiveSignal() {
@@ -263,6 +503,13 @@ func (e *Engine) receiveSignal() {
 			return fmt.Errorf("unknown peer %s", msg.Key)
 		}
 
+		plaintext, err := openSignalPayload(conn.Config.WgKey, conn.Config.RemoteWgKey, msg.Key, e.inboundNonces, msg.GetBody().GetPayload())
+		if err != nil {
+			log.Errorf("rejecting signal message from %s: %s", msg.Key, err)
+			return err
+		}
+		msg.Body.Payload = plaintext
+
 		switch msg.GetBody().Type {
 		case sProto.Body_OFFER:
 			remoteCred, err := signal.UnMarshalCredential(msg)
@@ -0,0 +1,64 @@
+package connection
+
+import (
+	"testing"
+	"time"
+)
+
+// TestSubscribeStatusChangesNotifiesOnStatusChange exercises the pub/sub
+// mechanism supervisePeer's reconnect path and WatchPeers both depend on.
+func TestSubscribeStatusChangesNotifiesOnStatusChange(t *testing.T) {
+	e := newTestEngine()
+
+	changed, cancel := e.SubscribeStatusChanges()
+	defer cancel()
+
+	e.setPeerStatus("peerA", &PeerStatus{State: StatusDisconnected})
+
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("expected a notification after setPeerStatus")
+	}
+
+	if status := e.PeerStatus("peerA"); status == nil || status.State != StatusDisconnected {
+		t.Fatalf("unexpected status after setPeerStatus: %+v", status)
+	}
+}
+
+// TestSubscribeStatusChangesCoalescesBurstsAndStopsAfterCancel verifies the
+// notification channel coalesces rapid-fire updates into a single pending
+// signal (rather than blocking setPeerStatus) and stops delivering once
+// cancelled.
+func TestSubscribeStatusChangesCoalescesBurstsAndStopsAfterCancel(t *testing.T) {
+	e := newTestEngine()
+
+	changed, cancel := e.SubscribeStatusChanges()
+
+	for i := 0; i < 5; i++ {
+		e.setPeerStatus("peerA", &PeerStatus{State: StatusConnecting, ReconnectAttempts: i})
+	}
+
+	select {
+	case <-changed:
+	default:
+		t.Fatal("expected a pending notification after a burst of status changes")
+	}
+
+	select {
+	case <-changed:
+		t.Fatal("expected the burst to coalesce into a single pending notification")
+	default:
+	}
+
+	cancel()
+	e.setPeerStatus("peerA", &PeerStatus{State: StatusConnected})
+
+	select {
+	case _, ok := <-changed:
+		if ok {
+			t.Fatal("expected no further notifications after cancel")
+		}
+	default:
+	}
+}
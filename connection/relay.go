@@ -0,0 +1,256 @@
+package connection
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+const (
+	// relayDialTimeout is how long we wait for the websocket handshake with the relay server.
+	relayDialTimeout = 10 * time.Second
+	// relayWriteTimeout bounds a single frame write to the relay connection.
+	relayWriteTimeout = 10 * time.Second
+	// relayAuthHeader carries the HMAC-signed auth token on the websocket upgrade request.
+	relayAuthHeader = "X-Wiretrustee-Relay-Auth"
+)
+
+// relayFrame is the on-wire envelope multiplexed over a single relay websocket
+// connection. RemoteKey addresses the peer a given frame is to/from so that many
+// peer sessions can share one TLS connection to the relay server.
+type relayFrame struct {
+	RemoteKey string
+	Payload   []byte
+}
+
+// RelayClient maintains a single websocket connection to a relay server and
+// multiplexes framed traffic for every peer that falls back to (or starts on)
+// the relay path. It is used by Connection as an alternative to a direct ICE/P2P
+// data path when one isn't available yet, or isn't reachable at all.
+type RelayClient struct {
+	serverURL string
+	sharedKey string
+	myKey     wgtypes.Key
+
+	mu      sync.Mutex
+	conn    *websocket.Conn
+	streams map[string]chan []byte // keyed by remote peer's WgPubKey
+	// closed is set once by Close and never cleared; it tells readLoop not to
+	// start a reconnectLoop after a connection drop that was actually a
+	// deliberate shutdown.
+	closed bool
+	// stopCh is closed by Close to cancel a reconnectLoop that's in the middle
+	// of its backoff wait.
+	stopCh chan struct{}
+}
+
+// NewRelayClient creates a RelayClient bound to a relay server URL. sharedKey is
+// the HMAC secret used to sign the auth token sent on connect.
+func NewRelayClient(serverURL string, myKey wgtypes.Key, sharedKey string) *RelayClient {
+	return &RelayClient{
+		serverURL: serverURL,
+		sharedKey: sharedKey,
+		myKey:     myKey,
+		streams:   map[string]chan []byte{},
+		stopCh:    make(chan struct{}),
+	}
+}
+
+// Connect dials the relay server and authenticates with an HMAC-signed token
+// bound to the local peer's WireGuard public key, then starts the read loop that
+// demultiplexes incoming frames to their registered peer streams.
+func (r *RelayClient) Connect() error {
+	u, err := url.Parse(r.serverURL)
+	if err != nil {
+		return fmt.Errorf("invalid relay url %s: %w", r.serverURL, err)
+	}
+
+	dialer := &websocket.Dialer{HandshakeTimeout: relayDialTimeout}
+	header := map[string][]string{relayAuthHeader: {r.signToken()}}
+	conn, _, err := dialer.Dial(u.String(), header)
+	if err != nil {
+		return fmt.Errorf("failed connecting to relay server %s: %w", r.serverURL, err)
+	}
+
+	r.mu.Lock()
+	r.conn = conn
+	r.mu.Unlock()
+
+	go r.readLoop()
+
+	log.Infof("connected to relay server %s", r.serverURL)
+	return nil
+}
+
+// reconnectLoop redials the relay server using the same exponential backoff
+// schedule as a peer's initial connection attempt, until Connect succeeds or
+// Close is called. readLoop starts this whenever the relay connection drops
+// on its own (as opposed to via Close) - without it, one transient relay-server
+// blip would permanently blackhole the relay fallback for the rest of the
+// process's life, defeating the point of having one.
+func (r *RelayClient) reconnectLoop() {
+	b := newConnectBackOff()
+	for {
+		select {
+		case <-r.stopCh:
+			return
+		default:
+		}
+
+		if err := r.Connect(); err != nil {
+			wait := b.NextBackOff()
+			log.Warnf("failed reconnecting to relay server %s, retrying in %s: %s", r.serverURL, wait, err)
+			select {
+			case <-time.After(wait):
+			case <-r.stopCh:
+				return
+			}
+			continue
+		}
+
+		log.Infof("reconnected to relay server %s", r.serverURL)
+		return
+	}
+}
+
+// signToken produces an HMAC(sharedKey, pubKey||timestamp) token so the relay
+// server can verify the connecting peer owns the WireGuard key it claims.
+func (r *RelayClient) signToken() string {
+	ts := time.Now().Unix()
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(ts))
+	msg = append([]byte(r.myKey.PublicKey().String()), msg...)
+
+	mac := hmac.New(sha256.New, []byte(r.sharedKey))
+	mac.Write(msg)
+	sig := mac.Sum(nil)
+
+	return fmt.Sprintf("%s.%d.%s", r.myKey.PublicKey().String(), ts, base64.RawURLEncoding.EncodeToString(sig))
+}
+
+// Register returns a channel that receives frames relayed from remoteKey, creating
+// it if this is the first time remoteKey is seen on this relay connection. It
+// fails if the relay websocket isn't currently connected - e.g. it dropped and
+// reconnectLoop hasn't reestablished it yet - rather than handing back a
+// channel that will never receive anything, which previously made a stale
+// relay registration look like a successful one.
+func (r *RelayClient) Register(remoteKey string) (chan []byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.conn == nil {
+		return nil, fmt.Errorf("relay client to %s is not connected", r.serverURL)
+	}
+
+	if ch, ok := r.streams[remoteKey]; ok {
+		return ch, nil
+	}
+	ch := make(chan []byte, 100)
+	r.streams[remoteKey] = ch
+	return ch, nil
+}
+
+// Unregister stops delivering frames for remoteKey and closes its channel.
+func (r *RelayClient) Unregister(remoteKey string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if ch, ok := r.streams[remoteKey]; ok {
+		close(ch)
+		delete(r.streams, remoteKey)
+	}
+}
+
+// Send writes a payload addressed to remoteKey on the shared relay connection.
+func (r *RelayClient) Send(remoteKey string, payload []byte) error {
+	r.mu.Lock()
+	conn := r.conn
+	r.mu.Unlock()
+
+	if conn == nil {
+		return fmt.Errorf("relay client to %s is not connected", r.serverURL)
+	}
+
+	if err := conn.SetWriteDeadline(time.Now().Add(relayWriteTimeout)); err != nil {
+		return err
+	}
+	return conn.WriteJSON(&relayFrame{RemoteKey: remoteKey, Payload: payload})
+}
+
+// Close tears down the relay websocket connection and all registered streams,
+// and stops readLoop from starting any further reconnectLoop.
+func (r *RelayClient) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		close(r.stopCh)
+	}
+	return r.closeLocked()
+}
+
+// closeLocked does the work of Close assuming r.mu is already held by the
+// caller. It's also used by readLoop so that a relay disconnect closes every
+// registered stream and clears r.conn - otherwise relayDataPath.Read would
+// block forever on a stream that's never going to receive another frame.
+func (r *RelayClient) closeLocked() error {
+	for remoteKey, ch := range r.streams {
+		close(ch)
+		delete(r.streams, remoteKey)
+	}
+
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+func (r *RelayClient) readLoop() {
+	for {
+		r.mu.Lock()
+		conn := r.conn
+		r.mu.Unlock()
+		if conn == nil {
+			return
+		}
+
+		var frame relayFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			log.Warnf("relay connection to %s closed: %s", r.serverURL, err)
+			r.mu.Lock()
+			_ = r.closeLocked()
+			closed := r.closed
+			r.mu.Unlock()
+			if !closed {
+				go r.reconnectLoop()
+			}
+			return
+		}
+
+		r.mu.Lock()
+		ch, ok := r.streams[frame.RemoteKey]
+		r.mu.Unlock()
+		if !ok {
+			log.Warnf("dropping relayed frame for unregistered peer %s", frame.RemoteKey)
+			continue
+		}
+
+		select {
+		case ch <- frame.Payload:
+		default:
+			log.Warnf("relay stream for peer %s is full, dropping frame", frame.RemoteKey)
+		}
+	}
+}
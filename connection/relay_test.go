@@ -0,0 +1,184 @@
+package connection
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+func mustGenRelayKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("failed generating key: %s", err)
+	}
+	return key
+}
+
+func TestRelayClientSignTokenIsVerifiable(t *testing.T) {
+	key := mustGenRelayKey(t)
+	client := NewRelayClient("ws://example.invalid", key, "shared-secret")
+
+	token := client.signToken()
+	parts := strings.SplitN(token, ".", 3)
+	if len(parts) != 3 {
+		t.Fatalf("expected a 3-part token, got %q", token)
+	}
+	if parts[0] != key.PublicKey().String() {
+		t.Fatalf("token's pubkey part %q doesn't match %q", parts[0], key.PublicKey().String())
+	}
+
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		t.Fatalf("token's timestamp part %q isn't an integer: %s", parts[1], err)
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(ts))
+	mac := hmac.New(sha256.New, []byte("shared-secret"))
+	mac.Write(append([]byte(parts[0]), msg...))
+	if want := base64.RawURLEncoding.EncodeToString(mac.Sum(nil)); parts[2] != want {
+		t.Fatalf("token's signature %q doesn't verify against the shared key, want %q", parts[2], want)
+	}
+}
+
+func TestRelayClientSendAndReceiveRoundTrip(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	sawAuthHeader := false
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sawAuthHeader = r.Header.Get(relayAuthHeader) != ""
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %s", err)
+			return
+		}
+		defer conn.Close()
+
+		var frame relayFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			return
+		}
+		// echo the frame straight back so the test can assert the client's
+		// registered stream receives exactly what it sent
+		_ = conn.WriteJSON(&frame)
+	}))
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	client := NewRelayClient(wsURL, mustGenRelayKey(t), "test-shared-key")
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+	defer client.Close()
+
+	if !sawAuthHeader {
+		t.Fatalf("expected an %s header on the relay upgrade request", relayAuthHeader)
+	}
+
+	const remoteKey = "remote-peer-pubkey"
+	stream, err := client.Register(remoteKey)
+	if err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+
+	if err := client.Send(remoteKey, []byte("hello")); err != nil {
+		t.Fatalf("Send failed: %s", err)
+	}
+
+	select {
+	case payload := <-stream:
+		if string(payload) != "hello" {
+			t.Fatalf("unexpected payload: %s", payload)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for the echoed payload")
+	}
+
+	client.Unregister(remoteKey)
+	if _, ok := <-stream; ok {
+		t.Fatalf("expected the stream channel to be closed after Unregister")
+	}
+}
+
+func TestRelayClientReadLoopClosesStreamsOnDisconnect(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %s", err)
+			return
+		}
+		// close immediately so the client's readLoop sees a ReadJSON error
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	client := NewRelayClient(wsURL, mustGenRelayKey(t), "test-shared-key")
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+	defer client.Close()
+
+	stream, err := client.Register("remote-peer-pubkey")
+	if err != nil {
+		t.Fatalf("Register failed: %s", err)
+	}
+
+	select {
+	case _, ok := <-stream:
+		if ok {
+			t.Fatalf("expected the stream channel to be closed after the relay connection drops")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for readLoop to close the stream after disconnect - a relayDataPath.Read would hang forever here")
+	}
+}
+
+func TestRelayClientReconnectsAfterDisconnect(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	var dials int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&dials, 1)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade failed: %s", err)
+			return
+		}
+		if n == 1 {
+			// drop the first connection immediately so the client's readLoop
+			// kicks off a reconnect; the second dial is left open.
+			conn.Close()
+		}
+	}))
+	defer srv.Close()
+
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+	client := NewRelayClient(wsURL, mustGenRelayKey(t), "test-shared-key")
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect failed: %s", err)
+	}
+	defer client.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt32(&dials) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the client to redial the relay server after the first connection dropped")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
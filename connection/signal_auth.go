@@ -0,0 +1,177 @@
+package connection
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// maxSignalMessageAge is how long a sealed OFFER/ANSWER/CANDIDATE envelope is
+// accepted after being sent; anything older is treated as a replay and rejected.
+const maxSignalMessageAge = 30 * time.Second
+
+// signalHKDFInfo namespaces the per-direction key derivation below so it can
+// never collide with some other use of the same ECDH secret.
+const signalHKDFInfo = "wiretrustee-signal-v1"
+
+// signalNonces tracks the highest nonce accepted per remote peer so that signal
+// envelopes must arrive with a strictly increasing nonce (replay protection).
+type signalNonces struct {
+	mu   sync.Mutex
+	seen map[string]uint64
+}
+
+func newSignalNonces() *signalNonces {
+	return &signalNonces{seen: map[string]uint64{}}
+}
+
+// checkNotSeen returns an error if nonce isn't strictly greater than the last one
+// accepted from peerKey. It does not record nonce - callers must call advance
+// only once the envelope carrying it has been authenticated, otherwise an
+// unauthenticated party could desync the replay window by injecting a forged
+// envelope at a guessed counter value.
+func (s *signalNonces) checkNotSeen(peerKey string, nonce uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, ok := s.seen[peerKey]; ok && nonce <= last {
+		return fmt.Errorf("replayed or out-of-order signal message from %s (nonce %d <= %d)", peerKey, nonce, last)
+	}
+	return nil
+}
+
+// advance records nonce as the highest accepted for peerKey.
+func (s *signalNonces) advance(peerKey string, nonce uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if last, ok := s.seen[peerKey]; !ok || nonce > last {
+		s.seen[peerKey] = nonce
+	}
+}
+
+// sharedSecret derives a Curve25519 ECDH shared secret between a local WireGuard
+// keypair and a remote peer's public key. WireGuard keys are already X25519 keys,
+// so this is a plain scalar multiplication.
+func sharedSecret(myKey wgtypes.Key, remoteKey wgtypes.Key) ([32]byte, error) {
+	var secret [32]byte
+	out, err := curve25519.X25519(myKey[:], remoteKey[:])
+	if err != nil {
+		return secret, fmt.Errorf("failed deriving shared secret with %s: %w", remoteKey.String(), err)
+	}
+	copy(secret[:], out)
+	return secret, nil
+}
+
+// directionalKey derives a key for one direction of a signal conversation from
+// the (symmetric) ECDH secret and the sender's public key. ECDH alone gives both
+// peers the identical secret regardless of direction, so without this, an OFFER
+// sealed by peer A and an ANSWER sealed by peer B would both encrypt under the
+// same key - mixing in the sender's public key via HKDF gives each direction its
+// own key, so a reused counter across directions never reuses a (key, nonce) pair.
+func directionalKey(secret [32]byte, senderPubKey wgtypes.Key) ([chacha20poly1305.KeySize]byte, error) {
+	var key [chacha20poly1305.KeySize]byte
+	kdf := hkdf.New(sha256.New, secret[:], nil, append([]byte(signalHKDFInfo), senderPubKey[:]...))
+	if _, err := io.ReadFull(kdf, key[:]); err != nil {
+		return key, fmt.Errorf("failed deriving directional signal key: %w", err)
+	}
+	return key, nil
+}
+
+// sealSignalPayload authenticates and encrypts plaintext (an OFFER/ANSWER
+// credential or an ICE candidate) under a key derived from the ECDH secret
+// between myKey and remoteKey and bound to myKey's public key as sender, so the
+// untrusted signal server only ever relays ciphertext. The wire format is
+// counter(8) || unixTimestamp(8) || ciphertext+tag, with the XChaCha20-Poly1305
+// nonce derived from the counter and both header fields authenticated as
+// associated data so they can't be tampered with in transit.
+func sealSignalPayload(myKey wgtypes.Key, remoteKey wgtypes.Key, counter uint64, plaintext []byte) ([]byte, error) {
+	secret, err := sharedSecret(myKey, remoteKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := directionalKey(secret, myKey.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 16)
+	binary.BigEndian.PutUint64(header[0:8], counter)
+	binary.BigEndian.PutUint64(header[8:16], uint64(time.Now().Unix()))
+
+	var nonce [chacha20poly1305.NonceSizeX]byte
+	binary.BigEndian.PutUint64(nonce[:8], counter)
+
+	ciphertext := aead.Seal(nil, nonce[:], plaintext, header)
+
+	return append(header, ciphertext...), nil
+}
+
+// openSignalPayload reverses sealSignalPayload. remoteKey is the public key of
+// the peer that sealed the envelope (the sender), used both for the ECDH secret
+// and to derive the same directional key sealSignalPayload used. Envelopes whose
+// timestamp is stale (older than maxSignalMessageAge) or whose counter isn't
+// strictly greater than the last one accepted from peerKey are rejected; the
+// replay window is only advanced once the authentication tag has been verified,
+// so a party without the shared secret can't desync it by injecting a forged
+// envelope at a guessed counter value.
+func openSignalPayload(myKey wgtypes.Key, remoteKey wgtypes.Key, peerKey string, nonces *signalNonces, envelope []byte) ([]byte, error) {
+	if len(envelope) < 16 {
+		return nil, fmt.Errorf("signal envelope from %s is too short", peerKey)
+	}
+	header := envelope[:16]
+	ciphertext := envelope[16:]
+
+	counter := binary.BigEndian.Uint64(header[0:8])
+	ts := int64(binary.BigEndian.Uint64(header[8:16]))
+
+	age := time.Since(time.Unix(ts, 0))
+	if age > maxSignalMessageAge || age < -maxSignalMessageAge {
+		return nil, fmt.Errorf("stale signal message from %s (timestamp %d)", peerKey, ts)
+	}
+
+	if err := nonces.checkNotSeen(peerKey, counter); err != nil {
+		return nil, err
+	}
+
+	secret, err := sharedSecret(myKey, remoteKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := directionalKey(secret, remoteKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := chacha20poly1305.NewX(key[:])
+	if err != nil {
+		return nil, err
+	}
+
+	var nonce [chacha20poly1305.NonceSizeX]byte
+	binary.BigEndian.PutUint64(nonce[:8], counter)
+
+	plaintext, err := aead.Open(nil, nonce[:], ciphertext, header)
+	if err != nil {
+		return nil, fmt.Errorf("failed authenticating signal message from %s: %w", peerKey, err)
+	}
+
+	nonces.advance(peerKey, counter)
+
+	return plaintext, nil
+}
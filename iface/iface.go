@@ -0,0 +1,91 @@
+// Package iface manages the local WireGuard tunnel interface. Create/Configure/etc
+// used to be free functions that assumed a kernel WireGuard device (Linux only).
+// They're now methods on WGIface, which picks a kernel or userspace backend
+// depending on platform - see iface_linux.go and iface_userspace.go.
+package iface
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+// WGIface manages a single WireGuard tunnel interface. Construct with
+// NewWGIface; Close is safe to call more than once.
+type WGIface struct {
+	Name    string
+	Address string
+
+	mu     sync.Mutex
+	closed bool
+	tun    wgTunDevice
+}
+
+// wgTunDevice is implemented once per platform: tunDeviceLinux (kernel, via
+// wgctrl + `ip link`) for Linux, tunDeviceUserspace (golang.zx2c4.com/wireguard
+// over wintun on Windows, utun on macOS) for Windows and macOS.
+type wgTunDevice interface {
+	Create() error
+	Configure(privateKey string) error
+	UpdatePeer(peerKey string, allowedIPs string, endpoint *net.UDPAddr) error
+	RemovePeer(peerKey string) error
+	ListenPort() (int, error)
+	Close() error
+}
+
+// NewWGIface creates a WGIface for the named tunnel interface with the given
+// local WireGuard address (CIDR), selecting the kernel or userspace backend for
+// the current platform. The interface isn't created on the system until Create
+// is called.
+func NewWGIface(name string, address string) (*WGIface, error) {
+	tun, err := newTunDevice(name, address)
+	if err != nil {
+		return nil, fmt.Errorf("failed creating tun device for interface %s: %w", name, err)
+	}
+
+	return &WGIface{
+		Name:    name,
+		Address: address,
+		tun:     tun,
+	}, nil
+}
+
+// Create creates the tunnel interface on the system.
+func (w *WGIface) Create() error {
+	return w.tun.Create()
+}
+
+// Configure sets the interface's WireGuard private key.
+func (w *WGIface) Configure(privateKey string) error {
+	return w.tun.Configure(privateKey)
+}
+
+// UpdatePeer adds or updates a WireGuard peer on the interface.
+func (w *WGIface) UpdatePeer(peerKey string, allowedIPs string, endpoint *net.UDPAddr) error {
+	return w.tun.UpdatePeer(peerKey, allowedIPs, endpoint)
+}
+
+// RemovePeer removes a WireGuard peer from the interface.
+func (w *WGIface) RemovePeer(peerKey string) error {
+	return w.tun.RemovePeer(peerKey)
+}
+
+// ListenPort returns the UDP port the interface is currently listening on.
+func (w *WGIface) ListenPort() (int, error) {
+	return w.tun.ListenPort()
+}
+
+// Close tears the tunnel interface down. It is idempotent: calling it more than
+// once (e.g. once from peer teardown and once from shutdown) is a no-op after
+// the first call.
+func (w *WGIface) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+
+	return w.tun.Close()
+}
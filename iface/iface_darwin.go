@@ -0,0 +1,28 @@
+//go:build darwin
+
+package iface
+
+import (
+	"fmt"
+	"os/exec"
+
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// defaultMTU matches the kernel WireGuard backend's default.
+const defaultMTU = 1420
+
+func createTUN(name string) (tun.Device, error) {
+	// macOS only allows utun devices to pick their own index; the kernel assigns
+	// the real name (utunN) once opened, which tun.Device.Name() reflects and
+	// tunDeviceUserspace.Create re-reads before configuring the interface.
+	return tun.CreateTUN(name, defaultMTU)
+}
+
+func assignAddress(name string, address string) error {
+	out, err := exec.Command("ifconfig", name, "inet", address, address, "alias").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w, %s", err, string(out))
+	}
+	return nil
+}
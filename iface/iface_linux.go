@@ -0,0 +1,123 @@
+//go:build linux
+
+package iface
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// tunDeviceLinux drives a kernel WireGuard device via `ip link` and wgctrl. This
+// is the original (pre-WGIface) Linux-only backend.
+type tunDeviceLinux struct {
+	name    string
+	address string
+}
+
+func newTunDevice(name string, address string) (wgTunDevice, error) {
+	return &tunDeviceLinux{name: name, address: address}, nil
+}
+
+func (t *tunDeviceLinux) Create() error {
+	if out, err := exec.Command("ip", "link", "add", "dev", t.name, "type", "wireguard").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed creating wireguard interface %s: %w, %s", t.name, err, string(out))
+	}
+
+	if out, err := exec.Command("ip", "address", "add", "dev", t.name, t.address).CombinedOutput(); err != nil {
+		return fmt.Errorf("failed assigning address %s to interface %s: %w, %s", t.address, t.name, err, string(out))
+	}
+
+	if out, err := exec.Command("ip", "link", "set", "dev", t.name, "up").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed bringing up interface %s: %w, %s", t.name, err, string(out))
+	}
+
+	return nil
+}
+
+func (t *tunDeviceLinux) Configure(privateKey string) error {
+	key, err := wgtypes.ParseKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	client, err := wgctrl.New()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.ConfigureDevice(t.name, wgtypes.Config{PrivateKey: &key})
+}
+
+func (t *tunDeviceLinux) UpdatePeer(peerKey string, allowedIPs string, endpoint *net.UDPAddr) error {
+	key, err := wgtypes.ParseKey(peerKey)
+	if err != nil {
+		return err
+	}
+
+	_, allowedNet, err := net.ParseCIDR(allowedIPs)
+	if err != nil {
+		return err
+	}
+
+	client, err := wgctrl.New()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.ConfigureDevice(t.name, wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{
+			{
+				PublicKey:         key,
+				Endpoint:          endpoint,
+				AllowedIPs:        []net.IPNet{*allowedNet},
+				ReplaceAllowedIPs: true,
+			},
+		},
+	})
+}
+
+func (t *tunDeviceLinux) RemovePeer(peerKey string) error {
+	key, err := wgtypes.ParseKey(peerKey)
+	if err != nil {
+		return err
+	}
+
+	client, err := wgctrl.New()
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.ConfigureDevice(t.name, wgtypes.Config{
+		Peers: []wgtypes.PeerConfig{{PublicKey: key, Remove: true}},
+	})
+}
+
+func (t *tunDeviceLinux) ListenPort() (int, error) {
+	client, err := wgctrl.New()
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	d, err := client.Device(t.name)
+	if err != nil {
+		return 0, err
+	}
+
+	return d.ListenPort, nil
+}
+
+func (t *tunDeviceLinux) Close() error {
+	if out, err := exec.Command("ip", "link", "del", "dev", t.name).CombinedOutput(); err != nil {
+		log.Warnf("failed removing interface %s (may already be gone): %s, %s", t.name, err, string(out))
+	}
+	return nil
+}
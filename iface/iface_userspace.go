@@ -0,0 +1,139 @@
+//go:build windows || darwin
+
+package iface
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/conn"
+	"golang.zx2c4.com/wireguard/device"
+	"golang.zx2c4.com/wireguard/tun"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// tunDeviceUserspace drives a userspace WireGuard device (golang.zx2c4.com/wireguard)
+// over a platform TUN driver: wireguard-windows' wintun on Windows, utun on macOS.
+// Used where a kernel WireGuard implementation isn't available.
+type tunDeviceUserspace struct {
+	name    string
+	address string
+
+	tunDevice tun.Device
+	wgDevice  *device.Device
+}
+
+func newTunDevice(name string, address string) (wgTunDevice, error) {
+	return &tunDeviceUserspace{name: name, address: address}, nil
+}
+
+func (t *tunDeviceUserspace) Create() error {
+	tunDevice, err := createTUN(t.name)
+	if err != nil {
+		return fmt.Errorf("failed creating tun device %s: %w", t.name, err)
+	}
+	t.tunDevice = tunDevice
+
+	// on macOS (and possibly other platforms) the driver is free to assign a
+	// different name than requested - e.g. utun devices only let the kernel
+	// pick their index, so a "wt0" request actually comes back as "utun7".
+	// Re-fetch the name the device actually ended up with so assignAddress
+	// below (and every later log line) targets the interface that exists,
+	// not the one we asked for.
+	if actualName, err := tunDevice.Name(); err == nil {
+		t.name = actualName
+	}
+
+	t.wgDevice = device.NewDevice(tunDevice, conn.NewDefaultBind(), device.NewLogger(device.LogLevelError, fmt.Sprintf("(%s) ", t.name)))
+
+	if err := assignAddress(t.name, t.address); err != nil {
+		return fmt.Errorf("failed assigning address %s to interface %s: %w", t.address, t.name, err)
+	}
+
+	return t.wgDevice.Up()
+}
+
+func (t *tunDeviceUserspace) Configure(privateKey string) error {
+	if t.wgDevice == nil {
+		return fmt.Errorf("interface %s: Configure called before Create", t.name)
+	}
+
+	key, err := wgtypes.ParseKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	return t.wgDevice.IpcSet(fmt.Sprintf("private_key=%s\n", hexKey(key)))
+}
+
+func (t *tunDeviceUserspace) UpdatePeer(peerKey string, allowedIPs string, endpoint *net.UDPAddr) error {
+	if t.wgDevice == nil {
+		return fmt.Errorf("interface %s: UpdatePeer called before Create", t.name)
+	}
+
+	key, err := wgtypes.ParseKey(peerKey)
+	if err != nil {
+		return err
+	}
+
+	cfg := strings.Builder{}
+	cfg.WriteString(fmt.Sprintf("public_key=%s\n", hexKey(key)))
+	cfg.WriteString("replace_allowed_ips=true\n")
+	cfg.WriteString(fmt.Sprintf("allowed_ip=%s\n", allowedIPs))
+	if endpoint != nil {
+		cfg.WriteString(fmt.Sprintf("endpoint=%s\n", endpoint.String()))
+	}
+
+	return t.wgDevice.IpcSet(cfg.String())
+}
+
+func (t *tunDeviceUserspace) RemovePeer(peerKey string) error {
+	if t.wgDevice == nil {
+		return fmt.Errorf("interface %s: RemovePeer called before Create", t.name)
+	}
+
+	key, err := wgtypes.ParseKey(peerKey)
+	if err != nil {
+		return err
+	}
+
+	return t.wgDevice.IpcSet(fmt.Sprintf("public_key=%s\nremove=true\n", hexKey(key)))
+}
+
+func (t *tunDeviceUserspace) ListenPort() (int, error) {
+	if t.wgDevice == nil {
+		return 0, fmt.Errorf("interface %s: ListenPort called before Create", t.name)
+	}
+
+	cfg, err := t.wgDevice.IpcGet()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(cfg, "\n") {
+		if strings.HasPrefix(line, "listen_port=") {
+			var port int
+			if _, err := fmt.Sscanf(line, "listen_port=%d", &port); err != nil {
+				return 0, err
+			}
+			return port, nil
+		}
+	}
+
+	return 0, fmt.Errorf("listen_port not found in device config")
+}
+
+func (t *tunDeviceUserspace) Close() error {
+	if t.wgDevice == nil {
+		return nil
+	}
+	t.wgDevice.Close()
+	log.Infof("closed userspace wireguard device %s", t.name)
+	return nil
+}
+
+func hexKey(key wgtypes.Key) string {
+	return fmt.Sprintf("%x", [32]byte(key))
+}
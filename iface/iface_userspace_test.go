@@ -0,0 +1,25 @@
+//go:build windows || darwin
+
+package iface
+
+import "testing"
+
+// TestTunDeviceUserspaceRejectsUseBeforeCreate guards against the nil wgDevice
+// panic a caller would otherwise hit by calling UpdatePeer/RemovePeer/Configure
+// on a WGIface that was constructed but never had Create run on it.
+func TestTunDeviceUserspaceRejectsUseBeforeCreate(t *testing.T) {
+	dev := &tunDeviceUserspace{name: "wt-test"}
+
+	if err := dev.Configure("not-a-real-key"); err == nil {
+		t.Fatal("expected Configure to error before Create")
+	}
+	if err := dev.UpdatePeer("not-a-real-key", "10.0.0.2/32", nil); err == nil {
+		t.Fatal("expected UpdatePeer to error before Create")
+	}
+	if err := dev.RemovePeer("not-a-real-key"); err == nil {
+		t.Fatal("expected RemovePeer to error before Create")
+	}
+	if _, err := dev.ListenPort(); err == nil {
+		t.Fatal("expected ListenPort to error before Create")
+	}
+}
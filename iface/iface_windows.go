@@ -0,0 +1,47 @@
+//go:build windows
+
+package iface
+
+import (
+	"fmt"
+	"os/exec"
+
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/tun"
+)
+
+// wireguardTunGUID is a fixed GUID namespace for wiretrustee tunnels, used so the
+// same interface name always maps to the same Windows network adapter GUID
+// (wireguard-windows' wintun driver requires one to be supplied, it won't
+// generate its own). wintun.dll is loaded lazily by tun.CreateTUNWithRequestedGUID
+// the first time it's needed.
+var wireguardTunGUID = windows.GUID{
+	Data1: 0x7e1ae6a4,
+	Data2: 0x4e31,
+	Data3: 0x4d5e,
+	Data4: [8]byte{0x9a, 0x2b, 0x5e, 0x3c, 0x1f, 0x8a, 0x6d, 0x02},
+}
+
+func createTUN(name string) (tun.Device, error) {
+	guid := adapterGUID(name)
+	return tun.CreateTUNWithRequestedGUID(name, &guid, 0)
+}
+
+// adapterGUID derives a stable per-interface GUID from wireguardTunGUID and the
+// interface name, so re-creating the same named interface reuses the same
+// Windows adapter instead of leaking a new one on every restart.
+func adapterGUID(name string) windows.GUID {
+	guid := wireguardTunGUID
+	for i, c := range []byte(name) {
+		guid.Data4[i%8] ^= c
+	}
+	return guid
+}
+
+func assignAddress(name string, address string) error {
+	out, err := exec.Command("netsh", "interface", "ip", "set", "address", name, "static", address).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w, %s", err, string(out))
+	}
+	return nil
+}
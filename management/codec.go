@@ -0,0 +1,23 @@
+package management
+
+import "encoding/json"
+
+// jsonCodec is a grpc encoding.Codec that marshals messages with encoding/json.
+// The request/response types in proto/management.pb.go are hand-written structs,
+// not output from protoc-gen-go, so they don't implement proto.Message and
+// grpc-go's default "proto" codec can't marshal them. Registering this via
+// grpc.ForceServerCodec on the server makes every RPC here actually work on the
+// wire; a real client must dial with grpc.ForceCodec(jsonCodec{}) to match.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
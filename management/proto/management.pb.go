@@ -0,0 +1,188 @@
+// Package proto holds hand-written Go counterparts of the messages and service
+// in management.proto. They are not protoc output and don't implement
+// proto.Message (see management/codec.go), so they are kept in step with the
+// .proto file by hand.
+package proto
+
+import (
+	context "context"
+
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// ConnectionState mirrors connection.ConnectionState on the wire.
+type ConnectionState int32
+
+const (
+	ConnectionState_CONNECTING   ConnectionState = 0
+	ConnectionState_CONNECTED    ConnectionState = 1
+	ConnectionState_DISCONNECTED ConnectionState = 2
+)
+
+type AddPeerRequest struct {
+	WgPubKey     string
+	WgAllowedIps string
+	Persistent   bool
+}
+
+type RemovePeerRequest struct {
+	WgPubKey string
+}
+
+type PeerResponse struct {
+	WgPubKey string
+}
+
+type ListPeersRequest struct{}
+
+type ListPeersResponse struct {
+	Peers []*PeerResponse
+}
+
+type GetStatusRequest struct {
+	WgPubKey string
+}
+
+type WatchPeersRequest struct{}
+
+type PeerStatusResponse struct {
+	WgPubKey          string
+	State             ConnectionState
+	LastError         string
+	ReconnectAttempts int32
+}
+
+// UnimplementedManagementServiceServer may be embedded to have forward compatible
+// implementations that panic with an explicit error if a method is called that
+// was not explicitly implemented.
+type UnimplementedManagementServiceServer struct{}
+
+func (UnimplementedManagementServiceServer) AddPeer(context.Context, *AddPeerRequest) (*PeerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AddPeer not implemented")
+}
+func (UnimplementedManagementServiceServer) RemovePeer(context.Context, *RemovePeerRequest) (*PeerResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RemovePeer not implemented")
+}
+func (UnimplementedManagementServiceServer) ListPeers(context.Context, *ListPeersRequest) (*ListPeersResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListPeers not implemented")
+}
+func (UnimplementedManagementServiceServer) GetStatus(context.Context, *GetStatusRequest) (*PeerStatusResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedManagementServiceServer) WatchPeers(*WatchPeersRequest, ManagementService_WatchPeersServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchPeers not implemented")
+}
+
+// ManagementServiceServer is the server API for ManagementService.
+type ManagementServiceServer interface {
+	AddPeer(context.Context, *AddPeerRequest) (*PeerResponse, error)
+	RemovePeer(context.Context, *RemovePeerRequest) (*PeerResponse, error)
+	ListPeers(context.Context, *ListPeersRequest) (*ListPeersResponse, error)
+	GetStatus(context.Context, *GetStatusRequest) (*PeerStatusResponse, error)
+	WatchPeers(*WatchPeersRequest, ManagementService_WatchPeersServer) error
+}
+
+// ManagementService_WatchPeersServer is the server-stream API for WatchPeers.
+type ManagementService_WatchPeersServer interface {
+	Send(*PeerStatusResponse) error
+	grpc.ServerStream
+}
+
+type managementServiceWatchPeersServer struct {
+	grpc.ServerStream
+}
+
+func (s *managementServiceWatchPeersServer) Send(m *PeerStatusResponse) error {
+	return s.ServerStream.SendMsg(m)
+}
+
+// RegisterManagementServiceServer registers srv as the handler for the
+// ManagementService on s.
+func RegisterManagementServiceServer(s grpc.ServiceRegistrar, srv ManagementServiceServer) {
+	s.RegisterService(&managementServiceDesc, srv)
+}
+
+func managementServiceAddPeerHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AddPeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServiceServer).AddPeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/management.ManagementService/AddPeer"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServiceServer).AddPeer(ctx, req.(*AddPeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func managementServiceRemovePeerHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RemovePeerRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServiceServer).RemovePeer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/management.ManagementService/RemovePeer"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServiceServer).RemovePeer(ctx, req.(*RemovePeerRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func managementServiceListPeersHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListPeersRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServiceServer).ListPeers(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/management.ManagementService/ListPeers"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServiceServer).ListPeers(ctx, req.(*ListPeersRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func managementServiceGetStatusHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServiceServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/management.ManagementService/GetStatus"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServiceServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func managementServiceWatchPeersHandler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchPeersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ManagementServiceServer).WatchPeers(m, &managementServiceWatchPeersServer{stream})
+}
+
+var managementServiceDesc = grpc.ServiceDesc{
+	ServiceName: "management.ManagementService",
+	HandlerType: (*ManagementServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "AddPeer", Handler: managementServiceAddPeerHandler},
+		{MethodName: "RemovePeer", Handler: managementServiceRemovePeerHandler},
+		{MethodName: "ListPeers", Handler: managementServiceListPeersHandler},
+		{MethodName: "GetStatus", Handler: managementServiceGetStatusHandler},
+	},
+	Streams: []grpc.StreamDesc{
+		{StreamName: "WatchPeers", Handler: managementServiceWatchPeersHandler, ServerStreams: true},
+	},
+	Metadata: "management.proto",
+}
@@ -0,0 +1,189 @@
+// Package management implements the local control-plane API that replaced the
+// old unauthenticated "POST /peer" HTTP endpoint. It exposes peer management
+// (add/remove/list/status/watch) over gRPC, secured with a Unix-domain socket by
+// default, or mTLS when remote control is configured.
+package management
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/wiretrustee/wiretrustee/connection"
+	"github.com/wiretrustee/wiretrustee/management/proto"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+// DefaultSocketPath is where the management gRPC server listens by default.
+const DefaultSocketPath = "/var/run/wiretrustee/management.sock"
+
+// PeerStore persists the peers a management client adds/removes so the list
+// survives a restart. cmd.Config implements this.
+type PeerStore interface {
+	AddPeer(peer connection.Peer) error
+	RemovePeer(wgPubKey string) error
+	ListPeers() []connection.Peer
+}
+
+// EngineHandle is the subset of *connection.Engine the management server drives.
+// Kept as an interface so the server can be exercised without a real Engine.
+type EngineHandle interface {
+	InitializePeer(wgPort int, myKey wgtypes.Key, peer connection.Peer)
+	RemovePeerConnection(peer connection.Peer) error
+	PeerStatus(peerKey string) *connection.PeerStatus
+	SubscribeStatusChanges() (changed <-chan struct{}, cancel func())
+}
+
+// Server is the gRPC ManagementService implementation.
+type Server struct {
+	proto.UnimplementedManagementServiceServer
+
+	engine  EngineHandle
+	store   PeerStore
+	wgPort  int
+	myKey   wgtypes.Key
+	grpcSrv *grpc.Server
+}
+
+// NewServer creates a management Server bound to engine for peer operations and
+// store for persisting the peer list across restarts.
+func NewServer(engine EngineHandle, store PeerStore, wgPort int, myKey wgtypes.Key) *Server {
+	return &Server{engine: engine, store: store, wgPort: wgPort, myKey: myKey}
+}
+
+// Listen starts serving the management API on a Unix-domain socket at
+// socketPath (chmod'd to 0600, owner-only, right after the socket file is
+// created - net.Listen itself honors the process umask, which on most systems
+// leaves it group/world accessible). Call with tlsCreds non-nil to instead
+// serve mTLS on a TCP address (used for remote control).
+func (s *Server) Listen(socketPath string, tlsCreds credentials.TransportCredentials) error {
+	var lis net.Listener
+	var err error
+	opts := []grpc.ServerOption{grpc.ForceServerCodec(jsonCodec{})}
+
+	if tlsCreds != nil {
+		lis, err = net.Listen("tcp", socketPath)
+		opts = append(opts, grpc.Creds(tlsCreds))
+	} else {
+		_ = os.Remove(socketPath)
+		lis, err = net.Listen("unix", socketPath)
+		if err == nil {
+			if chmodErr := os.Chmod(socketPath, 0600); chmodErr != nil {
+				_ = lis.Close()
+				return fmt.Errorf("failed restricting permissions on management socket %s: %w", socketPath, chmodErr)
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed listening on management socket %s: %w", socketPath, err)
+	}
+
+	s.grpcSrv = grpc.NewServer(opts...)
+	proto.RegisterManagementServiceServer(s.grpcSrv, s)
+
+	log.Infof("management API listening on %s", socketPath)
+	return s.grpcSrv.Serve(lis)
+}
+
+// Stop gracefully shuts the management server down.
+func (s *Server) Stop() {
+	if s.grpcSrv != nil {
+		s.grpcSrv.GracefulStop()
+	}
+}
+
+func (s *Server) AddPeer(ctx context.Context, req *proto.AddPeerRequest) (*proto.PeerResponse, error) {
+	peer := connection.Peer{
+		WgPubKey:     req.WgPubKey,
+		WgAllowedIps: req.WgAllowedIps,
+		Persistent:   req.Persistent,
+	}
+
+	if err := s.store.AddPeer(peer); err != nil {
+		return nil, fmt.Errorf("failed persisting peer %s: %w", peer.WgPubKey, err)
+	}
+
+	go s.engine.InitializePeer(s.wgPort, s.myKey, peer)
+
+	return &proto.PeerResponse{WgPubKey: peer.WgPubKey}, nil
+}
+
+func (s *Server) RemovePeer(ctx context.Context, req *proto.RemovePeerRequest) (*proto.PeerResponse, error) {
+	if err := s.store.RemovePeer(req.WgPubKey); err != nil {
+		return nil, fmt.Errorf("failed removing peer %s: %w", req.WgPubKey, err)
+	}
+
+	if err := s.engine.RemovePeerConnection(connection.Peer{WgPubKey: req.WgPubKey}); err != nil {
+		return nil, err
+	}
+
+	return &proto.PeerResponse{WgPubKey: req.WgPubKey}, nil
+}
+
+func (s *Server) ListPeers(ctx context.Context, req *proto.ListPeersRequest) (*proto.ListPeersResponse, error) {
+	var peers []*proto.PeerResponse
+	for _, peer := range s.store.ListPeers() {
+		peers = append(peers, &proto.PeerResponse{WgPubKey: peer.WgPubKey})
+	}
+	return &proto.ListPeersResponse{Peers: peers}, nil
+}
+
+func (s *Server) GetStatus(ctx context.Context, req *proto.GetStatusRequest) (*proto.PeerStatusResponse, error) {
+	status := s.engine.PeerStatus(req.WgPubKey)
+	if status == nil {
+		return nil, fmt.Errorf("unknown peer %s", req.WgPubKey)
+	}
+	return toStatusResponse(req.WgPubKey, status), nil
+}
+
+// WatchPeers streams the status of every known peer once on subscribe, then
+// again every time any peer's status changes, until the client disconnects.
+func (s *Server) WatchPeers(req *proto.WatchPeersRequest, stream proto.ManagementService_WatchPeersServer) error {
+	changed, cancel := s.engine.SubscribeStatusChanges()
+	defer cancel()
+
+	sendSnapshot := func() error {
+		for _, peer := range s.store.ListPeers() {
+			status := s.engine.PeerStatus(peer.WgPubKey)
+			if status == nil {
+				continue
+			}
+			if err := stream.Send(toStatusResponse(peer.WgPubKey, status)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := sendSnapshot(); err != nil {
+		return err
+	}
+
+	ctx := stream.Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+			if err := sendSnapshot(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func toStatusResponse(wgPubKey string, status *connection.PeerStatus) *proto.PeerStatusResponse {
+	resp := &proto.PeerStatusResponse{
+		WgPubKey:          wgPubKey,
+		State:             proto.ConnectionState(status.State),
+		ReconnectAttempts: int32(status.ReconnectAttempts),
+	}
+	if status.LastError != nil {
+		resp.LastError = status.LastError.Error()
+	}
+	return resp
+}
@@ -0,0 +1,200 @@
+package management
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/wiretrustee/wiretrustee/connection"
+	"github.com/wiretrustee/wiretrustee/management/proto"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+	"google.golang.org/grpc"
+)
+
+// fakeEngine is a minimal EngineHandle used to exercise Server without a real
+// connection.Engine.
+type fakeEngine struct {
+	mu       sync.Mutex
+	statuses map[string]*connection.PeerStatus
+	initd    []connection.Peer
+	removed  []connection.Peer
+	subs     map[chan struct{}]struct{}
+}
+
+func newFakeEngine() *fakeEngine {
+	return &fakeEngine{
+		statuses: map[string]*connection.PeerStatus{},
+		subs:     map[chan struct{}]struct{}{},
+	}
+}
+
+func (f *fakeEngine) InitializePeer(wgPort int, myKey wgtypes.Key, peer connection.Peer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.initd = append(f.initd, peer)
+	f.statuses[peer.WgPubKey] = &connection.PeerStatus{State: connection.StatusConnected}
+	f.notify()
+}
+
+func (f *fakeEngine) RemovePeerConnection(peer connection.Peer) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.removed = append(f.removed, peer)
+	delete(f.statuses, peer.WgPubKey)
+	f.notify()
+	return nil
+}
+
+func (f *fakeEngine) PeerStatus(peerKey string) *connection.PeerStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.statuses[peerKey]
+}
+
+func (f *fakeEngine) SubscribeStatusChanges() (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	f.mu.Lock()
+	f.subs[ch] = struct{}{}
+	f.mu.Unlock()
+
+	return ch, func() {
+		f.mu.Lock()
+		delete(f.subs, ch)
+		f.mu.Unlock()
+	}
+}
+
+// notify wakes up subscribers. Callers must hold f.mu.
+func (f *fakeEngine) notify() {
+	for ch := range f.subs {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+type fakeStore struct {
+	mu    sync.Mutex
+	peers []connection.Peer
+}
+
+func (s *fakeStore) AddPeer(peer connection.Peer) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, p := range s.peers {
+		if p.WgPubKey == peer.WgPubKey {
+			return errors.New("already exists")
+		}
+	}
+	s.peers = append(s.peers, peer)
+	return nil
+}
+
+func (s *fakeStore) RemovePeer(wgPubKey string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, p := range s.peers {
+		if p.WgPubKey == wgPubKey {
+			s.peers = append(s.peers[:i], s.peers[i+1:]...)
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
+
+func (s *fakeStore) ListPeers() []connection.Peer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]connection.Peer, len(s.peers))
+	copy(out, s.peers)
+	return out
+}
+
+func TestServerAddListRemovePeer(t *testing.T) {
+	engine := newFakeEngine()
+	store := &fakeStore{}
+	srv := NewServer(engine, store, 51820, wgtypes.Key{})
+
+	if _, err := srv.AddPeer(context.Background(), &proto.AddPeerRequest{WgPubKey: "peerA"}); err != nil {
+		t.Fatalf("AddPeer failed: %s", err)
+	}
+
+	listed, err := srv.ListPeers(context.Background(), &proto.ListPeersRequest{})
+	if err != nil {
+		t.Fatalf("ListPeers failed: %s", err)
+	}
+	if len(listed.Peers) != 1 || listed.Peers[0].WgPubKey != "peerA" {
+		t.Fatalf("unexpected peer list: %+v", listed.Peers)
+	}
+
+	if _, err := srv.RemovePeer(context.Background(), &proto.RemovePeerRequest{WgPubKey: "peerA"}); err != nil {
+		t.Fatalf("RemovePeer failed: %s", err)
+	}
+
+	listed, err = srv.ListPeers(context.Background(), &proto.ListPeersRequest{})
+	if err != nil {
+		t.Fatalf("ListPeers failed: %s", err)
+	}
+	if len(listed.Peers) != 0 {
+		t.Fatalf("expected no peers after removal, got %+v", listed.Peers)
+	}
+}
+
+// fakeWatchStream implements proto.ManagementService_WatchPeersServer enough to
+// drive WatchPeers without a real network connection.
+type fakeWatchStream struct {
+	grpc.ServerStream
+	ctx context.Context
+	out chan *proto.PeerStatusResponse
+}
+
+func (s *fakeWatchStream) Send(resp *proto.PeerStatusResponse) error {
+	s.out <- resp
+	return nil
+}
+
+func (s *fakeWatchStream) Context() context.Context {
+	return s.ctx
+}
+
+func TestServerWatchPeersStreamsUpdates(t *testing.T) {
+	engine := newFakeEngine()
+	store := &fakeStore{}
+	srv := NewServer(engine, store, 51820, wgtypes.Key{})
+
+	if err := store.AddPeer(connection.Peer{WgPubKey: "peerA"}); err != nil {
+		t.Fatalf("seeding store failed: %s", err)
+	}
+	engine.InitializePeer(51820, wgtypes.Key{}, connection.Peer{WgPubKey: "peerA"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &fakeWatchStream{ctx: ctx, out: make(chan *proto.PeerStatusResponse, 8)}
+
+	done := make(chan error, 1)
+	go func() { done <- srv.WatchPeers(&proto.WatchPeersRequest{}, stream) }()
+
+	// initial snapshot
+	resp := <-stream.out
+	if resp.WgPubKey != "peerA" {
+		t.Fatalf("unexpected initial snapshot: %+v", resp)
+	}
+
+	// a status change after subscribing must push a new update, not just a
+	// one-shot snapshot
+	engine.RemovePeerConnection(connection.Peer{WgPubKey: "peerA"})
+	engine.InitializePeer(51820, wgtypes.Key{}, connection.Peer{WgPubKey: "peerA"})
+
+	select {
+	case <-stream.out:
+	case <-time.After(time.Second):
+		t.Fatalf("expected a follow-up update after a status change")
+	}
+
+	cancel()
+	if err := <-done; err == nil {
+		t.Fatalf("expected WatchPeers to return once the client context is done")
+	}
+}